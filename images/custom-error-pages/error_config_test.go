@@ -0,0 +1,125 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestModifyOutputPrecedence asserts that maintenance env overrides beat
+// URIRule matches, which in turn beat the default "<code><ext>" file.
+func TestModifyOutputPrecedence(t *testing.T) {
+	const envVar = "TEST_CHUNK0_1_MAINTENANCE"
+	cfg := &ErrorConfig{
+		Services: []ServiceOverride{{
+			ServiceName: "refresh",
+			Maintenance: []MaintenanceOverride{{EnvVar: envVar, StatusCode: http.StatusServiceUnavailable}},
+			URIRules:    []URIRule{{URIPattern: "/version-checks/fresha", File: "refresh-fresha.json"}},
+		}},
+	}
+
+	t.Run("maintenance wins over URIRule", func(t *testing.T) {
+		t.Setenv(envVar, "down for maintenance")
+		errorConfig.Store(cfg)
+		t.Cleanup(func() { errorConfig.Store(&ErrorConfig{}) })
+
+		w := httptest.NewRecorder()
+		_, content, code := modifyOutput(w, ".html", "/www", "refresh", "/version-checks/fresha", 404)
+		if content == nil || content.Len() == 0 {
+			t.Fatalf("expected maintenance body, got nil/empty content")
+		}
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("code = %v, want %v", code, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("URIRule wins over default", func(t *testing.T) {
+		errorConfig.Store(cfg)
+		t.Cleanup(func() { errorConfig.Store(&ErrorConfig{}) })
+
+		w := httptest.NewRecorder()
+		filename, content, code := modifyOutput(w, ".html", "/www", "refresh", "/version-checks/fresha", 404)
+		if content != nil {
+			t.Fatalf("expected no in-memory content, got %q", content)
+		}
+		if want := "/www/refresh-fresha.json"; filename != want {
+			t.Errorf("filename = %q, want %q", filename, want)
+		}
+		if code != http.StatusOK {
+			t.Errorf("code = %v, want %v", code, http.StatusOK)
+		}
+	})
+
+	t.Run("default file when nothing matches", func(t *testing.T) {
+		errorConfig.Store(cfg)
+		t.Cleanup(func() { errorConfig.Store(&ErrorConfig{}) })
+
+		w := httptest.NewRecorder()
+		filename, content, code := modifyOutput(w, ".html", "/www", "refresh", "/some/other/path", 404)
+		if content != nil {
+			t.Fatalf("expected no in-memory content, got %q", content)
+		}
+		if want := "/www/404.html"; filename != want {
+			t.Errorf("filename = %q, want %q", filename, want)
+		}
+		if code != 0 {
+			t.Errorf("code = %v, want 0 (caller falls back to the request's own code)", code)
+		}
+	})
+}
+
+func TestLoadErrorConfigRegexErrors(t *testing.T) {
+	t.Run("invalid serviceNameRegex", func(t *testing.T) {
+		path := writeErrorConfig(t, `{"services":[{"serviceNameRegex":"("}]}`)
+		if _, err := loadErrorConfig(path); err == nil || !strings.Contains(err.Error(), "serviceNameRegex") {
+			t.Fatalf("loadErrorConfig() err = %v, want an error mentioning serviceNameRegex", err)
+		}
+	})
+
+	t.Run("invalid uriPattern regex", func(t *testing.T) {
+		path := writeErrorConfig(t, `{"services":[{"serviceName":"refresh","uriRules":[{"uriPattern":"(","regex":true,"file":"x.json"}]}]}`)
+		if _, err := loadErrorConfig(path); err == nil || !strings.Contains(err.Error(), "uriPattern") {
+			t.Fatalf("loadErrorConfig() err = %v, want an error mentioning uriPattern", err)
+		}
+	})
+
+	t.Run("valid config loads cleanly", func(t *testing.T) {
+		path := writeErrorConfig(t, `{"services":[{"serviceName":"refresh","uriRules":[{"uriPattern":"/x","file":"x.json"}]}]}`)
+		cfg, err := loadErrorConfig(path)
+		if err != nil {
+			t.Fatalf("loadErrorConfig() unexpected error: %v", err)
+		}
+		if len(cfg.Services) != 1 {
+			t.Fatalf("len(cfg.Services) = %v, want 1", len(cfg.Services))
+		}
+	})
+}
+
+func writeErrorConfig(t *testing.T, contents string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "error-config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -17,17 +17,29 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"mime"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	texttemplate "text/template"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -61,14 +73,650 @@ const (
 	// ErrFilesPathVar is the name of the environment variable indicating
 	// the location on disk of files served by the handler.
 	ErrFilesPathVar = "ERROR_FILES_PATH"
+
+	// ErrorConfigPathVar is the name of the environment variable pointing at a
+	// YAML or JSON file describing per-service error overrides. When unset,
+	// no overrides are loaded and every request is served from the default
+	// error files path.
+	ErrorConfigPathVar = "ERROR_CONFIG_PATH"
+
+	// MinCompressSizeVar is the name of the environment variable that
+	// overrides minCompressSize, the minimum response size in bytes below
+	// which compression is skipped.
+	MinCompressSizeVar = "MIN_COMPRESS_SIZE"
+
+	// CacheControl4xxVar overrides the Cache-Control sent for 4xx responses.
+	CacheControl4xxVar = "CACHE_CONTROL_4XX"
+
+	// CacheControl5xxVar overrides the Cache-Control sent for 5xx responses.
+	CacheControl5xxVar = "CACHE_CONTROL_5XX"
+
+	// MimeExtensionsConfigVar is the name of the environment variable
+	// pointing at a YAML or JSON file with extra extension<->MIME mappings,
+	// merged on top of preferredExtensions.
+	MimeExtensionsConfigVar = "MIME_EXTENSIONS_CONFIG_PATH"
+)
+
+// defaultCacheControl{4,5}xx are the Cache-Control values applied when the
+// operator hasn't overridden them via env var: 4xx pages are safe to cache
+// briefly at the edge, 5xx pages never are.
+const (
+	defaultCacheControl4xx = "public, max-age=60"
+	defaultCacheControl5xx = "no-store"
+)
+
+var (
+	cacheControl4xx = defaultCacheControl4xx
+	cacheControl5xx = defaultCacheControl5xx
 )
 
+// setSecurityHeaders sets the headers that apply to every response
+// regardless of branch, before the single WriteHeader call that follows it.
+func setSecurityHeaders(w http.ResponseWriter, code int) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if code >= 500 {
+		w.Header().Set("Cache-Control", cacheControl5xx)
+	} else {
+		w.Header().Set("Cache-Control", cacheControl4xx)
+	}
+}
+
+// preferredExtensions maps a MIME type to the extension used to name its
+// error files on disk, curated to avoid the surprises of
+// mime.ExtensionsByType (e.g. ".htm" winning over ".html" on some systems).
+// Overridable/extendable via MimeExtensionsConfigVar.
+var preferredExtensions = map[string]string{
+	"text/html":                ".html",
+	"text/plain":               ".txt",
+	"application/json":         ".json",
+	"application/vnd.api+json": ".json",
+	"application/xml":          ".xml",
+	"image/svg+xml":            ".svg",
+}
+
+func init() {
+	for mimeType, ext := range preferredExtensions {
+		mime.AddExtensionType(ext, mimeType)
+	}
+}
+
+// mimeExtensionsConfig is the shape of the file loaded from
+// MimeExtensionsConfigVar.
+type mimeExtensionsConfig struct {
+	// ExtensionToMIME registers additional extensions with the mime package,
+	// e.g. {".json-api": "application/vnd.api+json"}.
+	ExtensionToMIME map[string]string `json:"extensionToMime,omitempty"`
+	// MimeToExtension overrides/extends preferredExtensions.
+	MimeToExtension map[string]string `json:"mimeToExtension,omitempty"`
+}
+
+// loadMimeExtensions merges a curated extension registry on top of
+// preferredExtensions and registers any new extensions with the mime
+// package, so operators can teach the handler about formats it doesn't know
+// about out of the box.
+func loadMimeExtensions(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading mime extensions config: %v", err)
+	}
+
+	cfg := &mimeExtensionsConfig{}
+	if err := yaml.Unmarshal(raw, cfg); err != nil {
+		return fmt.Errorf("parsing mime extensions config: %v", err)
+	}
+
+	for ext, mimeType := range cfg.ExtensionToMIME {
+		if err := mime.AddExtensionType(ext, mimeType); err != nil {
+			return fmt.Errorf("registering extension %v for %v: %v", ext, mimeType, err)
+		}
+	}
+	for mimeType, ext := range cfg.MimeToExtension {
+		preferredExtensions[mimeType] = ext
+	}
+
+	return nil
+}
+
+// preferredExtension returns the on-disk extension, including the leading
+// dot, used to name error files for format.
+func preferredExtension(format string) string {
+	mediaType := format
+	if idx := strings.Index(mediaType, ";"); idx != -1 {
+		mediaType = mediaType[:idx]
+	}
+	mediaType = strings.TrimSpace(mediaType)
+
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if cext, err := mime.ExtensionsByType(mediaType); err == nil && len(cext) > 0 {
+		return cext[0]
+	}
+	return ".html"
+}
+
+// candidateFiles returns the fallback chain of error files tried for code,
+// in order, when format's preferred file is missing: the code-specific file
+// in the preferred extension, then ".html", then the same two for the "Nxx"
+// class file, then the same two for "default".
+func candidateFiles(path string, code int, ext string) []string {
+	scode := strconv.Itoa(code)
+	class := string(scode[0]) + "xx"
+	return []string{
+		fmt.Sprintf("%v/%v%v", path, code, ext),
+		fmt.Sprintf("%v/%v.html", path, code),
+		fmt.Sprintf("%v/%v%v", path, class, ext),
+		fmt.Sprintf("%v/%v.html", path, class),
+		fmt.Sprintf("%v/default%v", path, ext),
+		fmt.Sprintf("%v/default.html", path),
+	}
+}
+
+// defaultMinCompressSize is the default minimum response size, in bytes, worth
+// compressing. Smaller responses aren't worth the CPU/framing overhead.
+const defaultMinCompressSize = 1024
+
+var minCompressSize int64 = defaultMinCompressSize
+
+// compressibleFormats are the Content-Types eligible for on-the-fly gzip
+// compression when no precompressed variant exists on disk, in addition to
+// every text/* type. Anything else (images, fonts, already-compressed
+// formats) is served as-is.
+var compressibleFormats = map[string]bool{
+	"application/json":         true,
+	"application/vnd.api+json": true,
+	"application/xml":          true,
+	"image/svg+xml":            true,
+}
+
+// isCompressibleFormat reports whether format is worth gzip-compressing,
+// either because it's explicitly listed or because it's a text/* type.
+func isCompressibleFormat(format string) bool {
+	mediaType := format
+	if idx := strings.Index(mediaType, ";"); idx != -1 {
+		mediaType = mediaType[:idx]
+	}
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.HasPrefix(mediaType, "text/") || compressibleFormats[mediaType]
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding header lists
+// encoding without a "q=0" weight disabling it.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), encoding) {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") && strings.TrimPrefix(param, "q=") == "0" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriterPool reuses BestSpeed gzip.Writers across requests to avoid
+// reallocating their internal buffers for every compressed response.
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		gw, _ := gzip.NewWriterLevel(io.Discard, gzip.BestSpeed)
+		return gw
+	},
+}
+
+// openPrecompressed looks for filename+".br" and filename+".gz" siblings and
+// opens whichever one the client's Accept-Encoding allows, preferring
+// brotli. Returns the opened file, the Content-Encoding it was opened for
+// (empty when falling back to filename itself), and any error from opening
+// filename itself.
+func openPrecompressed(filename string, r *http.Request) (*os.File, string, error) {
+	if acceptsEncoding(r, "br") {
+		if f, err := os.Open(filename + ".br"); err == nil {
+			return f, "br", nil
+		}
+	}
+	if acceptsEncoding(r, "gzip") {
+		if f, err := os.Open(filename + ".gz"); err == nil {
+			return f, "gzip", nil
+		}
+	}
+	f, err := os.Open(filename)
+	return f, "", err
+}
+
+// statusOverrideWriter lets http.ServeContent keep handling conditional
+// requests (304) and Range requests (206/416) while the caller still gets
+// the last word on the "everything's fine, here's the body" status: a
+// WriteHeader(200) from ServeContent is rewritten to status, but 206/304/416
+// pass through untouched.
+type statusOverrideWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusOverrideWriter) WriteHeader(code int) {
+	if s.wroteHeader {
+		return
+	}
+	s.wroteHeader = true
+	if code == http.StatusOK && s.status != 0 && s.status != http.StatusOK {
+		code = s.status
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// isNotModified reports whether modTime satisfies the request's
+// If-Modified-Since, meaning the client's cached copy is still fresh and a
+// 304 should be returned instead of a body. A zero modTime (in-memory
+// content with no meaningful last-modified time, e.g. maintenance bodies)
+// never matches, so override responses are never short-circuited by this.
+func isNotModified(r *http.Request, modTime time.Time) bool {
+	if modTime.IsZero() {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// writeBody writes code and body to w, negotiating Content-Encoding. If
+// encoding is already set (the body came from a precompressed file) it's
+// passed straight through with the matching header. Otherwise, for
+// compressible formats over minCompressSize, body is gzip-compressed
+// on-the-fly when the client accepts it. When body also implements
+// io.ReadSeeker (a file, or any in-memory reader), serving goes through
+// http.ServeContent so clients get Last-Modified/If-Modified-Since and
+// Range handling for free. No ETag header is set, so ServeContent does no
+// ETag/If-None-Match matching. The on-the-fly gzip path can't support that since
+// it re-encodes the stream, so it keeps writing directly.
+func writeBody(w http.ResponseWriter, r *http.Request, format string, code int, name string, modTime time.Time, body io.Reader, size int64, encoding string) {
+	setSecurityHeaders(w, code)
+
+	// Honor If-Modified-Since before doing any compression work: the
+	// on-the-fly gzip path below never reaches http.ServeContent, so
+	// without this check a client polling a compressible, cacheable error
+	// page would never get a 304 and would always pay for re-compression.
+	if isNotModified(r, modTime) {
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		requestContentEncoding.WithLabelValues("not-modified").Inc()
+		return
+	}
+
+	if encoding == "" && isCompressibleFormat(format) && (size < 0 || size >= minCompressSize) && acceptsEncoding(r, "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.WriteHeader(code)
+		gw := gzipWriterPool.Get().(*gzip.Writer)
+		gw.Reset(w)
+		io.Copy(gw, body)
+		gw.Close()
+		gzipWriterPool.Put(gw)
+		requestContentEncoding.WithLabelValues("gzip").Inc()
+		return
+	}
+
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	if rs, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(&statusOverrideWriter{ResponseWriter: w, status: code}, r, name, modTime, rs)
+	} else {
+		w.WriteHeader(code)
+		io.Copy(w, body)
+	}
+
+	encodingLabel := encoding
+	if encodingLabel == "" {
+		encodingLabel = "identity"
+	}
+	requestContentEncoding.WithLabelValues(encodingLabel).Inc()
+}
+
+// URIRule maps a request URI (matched either as a shell-style glob or as a
+// regular expression) to a static file that should be served instead of the
+// default "<code><ext>" file, optionally overriding the status code and
+// Content-Type of the response.
+type URIRule struct {
+	// URIPattern is matched against the full X-Original-URI. Treated as a
+	// regular expression when Regex is true, otherwise as a glob per
+	// path.Match: "*" matches any sequence of non-"/" characters and "?"
+	// matches a single non-"/" character, so a pattern is anchored end to
+	// end rather than matched as a substring: "/version-checks/*" matches
+	// "/version-checks/fresha" but not "/api/version-checks/fresha". A glob
+	// cannot match a segment at an arbitrary depth; a Regex pattern can.
+	URIPattern string `json:"uriPattern"`
+	// Regex marks URIPattern as a regular expression instead of a glob.
+	Regex bool `json:"regex,omitempty"`
+	// File is the name of the file to serve, relative to the error files path.
+	File string `json:"file"`
+	// StatusCode overrides the status code normally written for the request.
+	// Defaults to http.StatusOK, matching the historical refresh behavior.
+	StatusCode int `json:"statusCode,omitempty"`
+	// ContentType overrides the Content-Type of the response when set.
+	ContentType string `json:"contentType,omitempty"`
+
+	compiled *regexp.Regexp
+}
+
+// MaintenanceOverride serves a fixed body sourced from an environment
+// variable whenever that variable is non-empty, taking precedence over any
+// URIRule match or the default error file.
+type MaintenanceOverride struct {
+	// EnvVar names the environment variable holding the maintenance body.
+	// The override only applies while this variable is set and non-empty.
+	EnvVar string `json:"envVar"`
+	// StatusCode overrides the status code used while serving the body.
+	// Defaults to http.StatusOK.
+	StatusCode int `json:"statusCode,omitempty"`
+	// ContentType overrides the Content-Type of the response when set.
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// ServiceOverride groups the override rules that apply to requests matching
+// a given X-Service-Name.
+type ServiceOverride struct {
+	// ServiceName is matched exactly against X-Service-Name.
+	ServiceName string `json:"serviceName,omitempty"`
+	// ServiceNameRegex, when set, is matched as a regular expression against
+	// X-Service-Name instead of an exact match.
+	ServiceNameRegex string `json:"serviceNameRegex,omitempty"`
+	// Headers are set on every response matched by this override, e.g. CORS
+	// headers.
+	Headers map[string]string `json:"headers,omitempty"`
+	// URIRules are evaluated in order; the first match wins.
+	URIRules []URIRule `json:"uriRules,omitempty"`
+	// Maintenance overrides are evaluated before URIRules, in order; the
+	// first one whose EnvVar is non-empty wins.
+	Maintenance []MaintenanceOverride `json:"maintenance,omitempty"`
+
+	compiledServiceName *regexp.Regexp
+}
+
+// ErrorConfig is the root of the file loaded from ErrorConfigPathVar.
+type ErrorConfig struct {
+	Services []ServiceOverride `json:"services"`
+}
+
+// errorConfig holds the currently active *ErrorConfig, swapped atomically on
+// reload so in-flight requests never observe a half-updated config.
+var errorConfig atomic.Value
+
+var (
+	requestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nginx_ingress_controller_default_backend_requests_total",
+		Help: "Counter of requests processed by the default backend, broken down by HTTP proto.",
+	}, []string{"proto"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "nginx_ingress_controller_default_backend_requests_duration_seconds",
+		Help: "Histogram of the time, in seconds, spent serving requests by the default backend.",
+	}, []string{"proto"})
+
+	requestContentEncoding = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nginx_ingress_controller_default_backend_response_content_encoding_total",
+		Help: "Counter of default backend responses, broken down by Content-Encoding.",
+	}, []string{"content_encoding"})
+
+	templateErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nginx_ingress_controller_default_backend_template_errors_total",
+		Help: "Counter of errors parsing or executing .tmpl error page templates.",
+	})
+
+	fileResolution = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nginx_ingress_controller_default_backend_file_resolution_total",
+		Help: "Counter of error files served, broken down by file and fallback chain depth (0 = primary file).",
+	}, []string{"file", "fallback_depth"})
+)
+
+func init() {
+	prometheus.MustRegister(requestCount, requestDuration, requestContentEncoding, templateErrors, fileResolution)
+}
+
+// execTemplate is satisfied by both *html/template.Template and
+// *text/template.Template so renderTemplate can pick the right parser for
+// the response format without duplicating the caching/execution logic.
+type execTemplate interface {
+	Execute(wr io.Writer, data interface{}) error
+}
+
+// cachedTemplate pairs a parsed template with the mtime it was parsed from,
+// so templateCache can detect on-disk edits and reparse on next use.
+type cachedTemplate struct {
+	modTime time.Time
+	tmpl    execTemplate
+}
+
+// templateCache holds parsed .tmpl files keyed by path, invalidated by mtime.
+var templateCache sync.Map // map[string]cachedTemplate
+
+// templateData is exposed to error page templates.
+type templateData struct {
+	Code        int
+	Format      string
+	OriginalURI string
+	Namespace   string
+	IngressName string
+	ServiceName string
+	ServicePort string
+	RequestID   string
+	Now         time.Time
+	Headers     map[string]string
+}
+
+// buildTemplateData collects the request context exposed to error page
+// templates, including every X-* header so operators aren't limited to the
+// handful of named fields.
+func buildTemplateData(r *http.Request, code int, format string) templateData {
+	headers := map[string]string{}
+	for name := range r.Header {
+		if strings.HasPrefix(name, "X-") {
+			headers[name] = r.Header.Get(name)
+		}
+	}
+	return templateData{
+		Code:        code,
+		Format:      format,
+		OriginalURI: r.Header.Get(OriginalURI),
+		Namespace:   r.Header.Get(Namespace),
+		IngressName: r.Header.Get(IngressName),
+		ServiceName: r.Header.Get(ServiceName),
+		ServicePort: r.Header.Get(ServicePort),
+		RequestID:   r.Header.Get(RequestId),
+		Now:         time.Now(),
+		Headers:     headers,
+	}
+}
+
+// getTemplate parses templatePath, using html/template for HTML responses
+// and text/template otherwise, and caches the result keyed by mtime so a
+// hot-reloaded error page doesn't require a process restart.
+func getTemplate(templatePath string, format string) (execTemplate, error) {
+	fi, err := os.Stat(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := templateCache.Load(templatePath); ok {
+		c := cached.(cachedTemplate)
+		if c.modTime.Equal(fi.ModTime()) {
+			return c.tmpl, nil
+		}
+	}
+
+	var tmpl execTemplate
+	if strings.Contains(format, "html") {
+		tmpl, err = template.ParseFiles(templatePath)
+	} else {
+		tmpl, err = texttemplate.ParseFiles(templatePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	templateCache.Store(templatePath, cachedTemplate{modTime: fi.ModTime(), tmpl: tmpl})
+	return tmpl, nil
+}
+
+// renderTemplate renders filename+".tmpl" when present, returning ok=false
+// when no such sibling exists (the caller should fall through to serving
+// filename as a static file) or when parsing/execution failed (logged and
+// counted, then also falls through rather than failing the request).
+func renderTemplate(filename string, format string, r *http.Request, code int) (io.ReadSeeker, int64, bool) {
+	templatePath := filename + ".tmpl"
+	if _, err := os.Stat(templatePath); err != nil {
+		return nil, 0, false
+	}
+
+	tmpl, err := getTemplate(templatePath, format)
+	if err != nil {
+		log.Printf("unexpected error parsing template %v: %v", templatePath, err)
+		templateErrors.Inc()
+		return nil, 0, false
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, buildTemplateData(r, code, format)); err != nil {
+		log.Printf("unexpected error executing template %v: %v", templatePath, err)
+		templateErrors.Inc()
+		return nil, 0, false
+	}
+
+	body := bytes.NewReader(buf.Bytes())
+	return body, int64(body.Len()), true
+}
+
+// matches reports whether name satisfies the override's service name
+// selector, either the exact ServiceName or the compiled ServiceNameRegex.
+func (s *ServiceOverride) matches(name string) bool {
+	if s.compiledServiceName != nil {
+		return s.compiledServiceName.MatchString(name)
+	}
+	return s.ServiceName == name
+}
+
+// matches reports whether uri satisfies the rule's URIPattern, either as a
+// compiled regular expression or a path.Match glob. A malformed glob (e.g.
+// an unterminated "[") never matches rather than erroring.
+func (u *URIRule) matches(uri string) bool {
+	if u.compiled != nil {
+		return u.compiled.MatchString(uri)
+	}
+	matched, err := path.Match(u.URIPattern, uri)
+	return err == nil && matched
+}
+
+// loadErrorConfig reads and parses the config file at path, compiling any
+// regular expressions up front so errors are surfaced at load time rather
+// than on the first matching request.
+func loadErrorConfig(path string) (*ErrorConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading error config: %v", err)
+	}
+
+	cfg := &ErrorConfig{}
+	if err := yaml.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parsing error config: %v", err)
+	}
+
+	for si := range cfg.Services {
+		svc := &cfg.Services[si]
+		if svc.ServiceNameRegex != "" {
+			re, err := regexp.Compile(svc.ServiceNameRegex)
+			if err != nil {
+				return nil, fmt.Errorf("compiling serviceNameRegex %q: %v", svc.ServiceNameRegex, err)
+			}
+			svc.compiledServiceName = re
+		}
+		for ri := range svc.URIRules {
+			rule := &svc.URIRules[ri]
+			if rule.Regex {
+				re, err := regexp.Compile(rule.URIPattern)
+				if err != nil {
+					return nil, fmt.Errorf("compiling uriPattern %q: %v", rule.URIPattern, err)
+				}
+				rule.compiled = re
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// watchErrorConfigReload reloads the error config from path every time the
+// process receives SIGHUP, so operators can adjust maintenance responses and
+// routing rules without restarting the pod.
+func watchErrorConfigReload(path string) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	for range sigs {
+		cfg, err := loadErrorConfig(path)
+		if err != nil {
+			log.Printf("error reloading error config from %v: %v", path, err)
+			continue
+		}
+		errorConfig.Store(cfg)
+		log.Printf("reloaded error config from %v", path)
+	}
+}
+
 func main() {
 	errFilesPath := "/www"
 	if os.Getenv(ErrFilesPathVar) != "" {
 		errFilesPath = os.Getenv(ErrFilesPathVar)
 	}
 
+	if v := os.Getenv(MinCompressSizeVar); v != "" {
+		if size, err := strconv.ParseInt(v, 10, 64); err == nil {
+			minCompressSize = size
+		} else {
+			log.Printf("invalid %v value %q, using default of %v bytes", MinCompressSizeVar, v, defaultMinCompressSize)
+		}
+	}
+
+	if v := os.Getenv(CacheControl4xxVar); v != "" {
+		cacheControl4xx = v
+	}
+	if v := os.Getenv(CacheControl5xxVar); v != "" {
+		cacheControl5xx = v
+	}
+
+	if mimeConfigPath := os.Getenv(MimeExtensionsConfigVar); mimeConfigPath != "" {
+		if err := loadMimeExtensions(mimeConfigPath); err != nil {
+			log.Fatalf("unable to load mime extensions config from %v: %v", mimeConfigPath, err)
+		}
+	}
+
+	if configPath := os.Getenv(ErrorConfigPathVar); configPath != "" {
+		cfg, err := loadErrorConfig(configPath)
+		if err != nil {
+			log.Fatalf("unable to load error config from %v: %v", configPath, err)
+		}
+		errorConfig.Store(cfg)
+		go watchErrorConfigReload(configPath)
+	} else {
+		errorConfig.Store(&ErrorConfig{})
+	}
+
 	http.HandleFunc("/", errorHandler(errFilesPath))
 
 	http.Handle("/metrics", promhttp.Handler())
@@ -80,51 +728,66 @@ func main() {
 	http.ListenAndServe(fmt.Sprintf(":8080"), nil)
 }
 
-func modifyOutput(w http.ResponseWriter, ext string, path string, service string, uri string, headers map[string]string, endpoints map[string]map[string]string, ingressCode int, returnCode int) (string, *strings.Reader, int) {
-	filename := ""
-	var content *strings.Reader
-	var customCode int
+// modifyOutput resolves the response for a request matched to a configured
+// service override. Precedence, highest first, is: maintenance env override,
+// URIRule match, default "<code><ext>" file.
+func modifyOutput(w http.ResponseWriter, ext string, path string, service string, uri string, code int) (string, *strings.Reader, int) {
+	filename := fmt.Sprintf("%v/%v%v", path, code, ext)
+
+	cfg, _ := errorConfig.Load().(*ErrorConfig)
+	if cfg == nil {
+		return filename, nil, 0
+	}
+
+	var svc *ServiceOverride
+	for i := range cfg.Services {
+		if cfg.Services[i].matches(service) {
+			svc = &cfg.Services[i]
+			break
+		}
+	}
+	if svc == nil {
+		return filename, nil, 0
+	}
 
 	log.Printf("Detected request to %v. Mocking response", service)
-	for header, value := range headers {
+	for header, value := range svc.Headers {
 		w.Header().Set(header, value)
 	}
-	// default file based on ingress code and file extension
-	filename = fmt.Sprintf("%v/%v%v", path, ingressCode, ext)
 
-	// Choose custom response file for if endpoint listed
-	for endpoint, config := range endpoints {
-		if os.Getenv(config["env"]) != "" {
-			content = strings.NewReader(os.Getenv(config["env"]))
-		} else if strings.Contains(uri, endpoint) {
-			customCode = http.StatusOK
-			filename = fmt.Sprintf("%v/%v", path, config["file"])
+	for _, m := range svc.Maintenance {
+		if body := os.Getenv(m.EnvVar); body != "" {
+			if m.ContentType != "" {
+				w.Header().Set(ContentType, m.ContentType)
+			}
+			statusCode := m.StatusCode
+			if statusCode == 0 {
+				statusCode = http.StatusOK
+			}
+			return filename, strings.NewReader(body), statusCode
+		}
+	}
+
+	for _, rule := range svc.URIRules {
+		if rule.matches(uri) {
+			if rule.ContentType != "" {
+				w.Header().Set(ContentType, rule.ContentType)
+			}
+			statusCode := rule.StatusCode
+			if statusCode == 0 {
+				statusCode = http.StatusOK
+			}
+			return fmt.Sprintf("%v/%v", path, rule.File), nil, statusCode
 		}
 	}
 
-	return filename, content, customCode
+	return filename, nil, 0
 }
 
 func errorHandler(path string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		refreshHeaders := map[string]string{
-			"Content-Type":                     "application/vnd.api+json; charset=utf-8",
-			"Access-Control-Allow-Origin":      r.Header.Get("Origin"),
-			"Access-Control-Allow-Credentials": "true",
-		}
-		refreshEndpoints := map[string]map[string]string{
-			"/version-checks/fresha": {
-				"file": "refresh-fresha.json",
-				"env":  "REFRESH_FRESHA_MAINTENANCE"},
-			"/version-checks/shedul": {
-				"file": "refresh-shedul.json",
-				"env":  "REFRESH_SHEDUL_MAINTENANCE"},
-		}
-
-		filename := ""
 		var content *strings.Reader = nil
 		start := time.Now()
-		ext := "html"
 
 		if os.Getenv("DEBUG") != "" {
 			w.Header().Set(FormatHeader, r.Header.Get(FormatHeader))
@@ -143,16 +806,7 @@ func errorHandler(path string) func(http.ResponseWriter, *http.Request) {
 			format = "text/html"
 			log.Printf("format not specified. Using %v", format)
 		}
-
-		cext, err := mime.ExtensionsByType(format)
-		if err != nil {
-			log.Printf("unexpected error reading media type extension: %v. Using %v", err, ext)
-			format = "text/html"
-		} else if len(cext) == 0 {
-			log.Printf("couldn't get media type extension. Using %v", ext)
-		} else {
-			ext = cext[0]
-		}
+		ext := preferredExtension(format)
 		w.Header().Set(ContentType, format)
 
 		errCode := r.Header.Get(CodeHeader)
@@ -161,43 +815,21 @@ func errorHandler(path string) func(http.ResponseWriter, *http.Request) {
 			code = 404
 			log.Printf("unexpected error reading return code: %v. Using %v", err, code)
 		}
-		customCode := code
-		if !strings.HasPrefix(ext, ".") {
-			ext = "." + ext
-		}
-		// Custom extension only used by refresh application
+
 		uri := r.Header.Get(OriginalURI)
 		serviceName := r.Header.Get(ServiceName)
-		if serviceName == "refresh" {
-			filename, content, customCode = modifyOutput(w, ext, path, serviceName, uri, refreshHeaders, refreshEndpoints, code, 200)
-		} else {
-			filename = fmt.Sprintf("%v/%v%v", path, code, ext)
+		filename, content, customCode := modifyOutput(w, ext, path, serviceName, uri, code)
+		if customCode == 0 {
+			customCode = code
 		}
+
 		if content != nil {
-			w.WriteHeader(customCode)
-			io.Copy(w, content)
-		} else {
-			f, err := os.Open(filename)
-			if err != nil {
-				log.Printf("unexpected error opening file: %v", err)
-				scode := strconv.Itoa(code)
-				filename = fmt.Sprintf("%v/%cxx%v", path, scode[0], ext)
-				f, err := os.Open(filename)
-				if err != nil {
-					log.Printf("unexpected error opening file: %v", err)
-					http.NotFound(w, r)
-					return
-				}
-				defer f.Close()
-				log.Printf("serving custom error response for code %v and format %v from file %v", code, format, filename)
-				w.WriteHeader(code)
-				io.Copy(w, f)
-				return
-			}
-			defer f.Close()
-			log.Printf("serving custom error response for code %v and format %v from file %v", code, format, filename)
-			w.WriteHeader(customCode)
-			io.Copy(w, f)
+			writeBody(w, r, format, customCode, "", time.Time{}, content, int64(content.Len()), "")
+		} else if !resolveAndServe(w, r, path, filename, code, customCode, format, ext) {
+			log.Printf("unexpected error opening file: no candidate error file found for code %v", code)
+			setSecurityHeaders(w, http.StatusNotFound)
+			http.NotFound(w, r)
+			return
 		}
 
 		duration := time.Now().Sub(start).Seconds()
@@ -209,3 +841,52 @@ func errorHandler(path string) func(http.ResponseWriter, *http.Request) {
 		requestDuration.WithLabelValues(proto).Observe(duration)
 	}
 }
+
+// resolveAndServe tries primary first (at status customCode, the file/status
+// chosen by modifyOutput), then falls through candidateFiles(path, code, ext)
+// in order (at status code, the original error code) until one resolves,
+// either as a .tmpl template or a static file. Reports whether a response
+// was written.
+func resolveAndServe(w http.ResponseWriter, r *http.Request, path string, primary string, code int, customCode int, format string, ext string) bool {
+	tried := map[string]bool{}
+
+	serve := func(candidate string, status int, depth int) bool {
+		if tried[candidate] {
+			return false
+		}
+		tried[candidate] = true
+
+		if body, size, ok := renderTemplate(candidate, format, r, status); ok {
+			fileResolution.WithLabelValues(candidate, strconv.Itoa(depth)).Inc()
+			writeBody(w, r, format, status, candidate, time.Time{}, body, size, "")
+			return true
+		}
+
+		f, encoding, err := openPrecompressed(candidate, r)
+		if err != nil {
+			return false
+		}
+		defer f.Close()
+
+		size := int64(-1)
+		modTime := time.Time{}
+		if fi, err := f.Stat(); err == nil {
+			size = fi.Size()
+			modTime = fi.ModTime()
+		}
+		fileResolution.WithLabelValues(candidate, strconv.Itoa(depth)).Inc()
+		log.Printf("serving custom error response for code %v and format %v from file %v", code, format, candidate)
+		writeBody(w, r, format, status, candidate, modTime, f, size, encoding)
+		return true
+	}
+
+	if serve(primary, customCode, 0) {
+		return true
+	}
+	for depth, candidate := range candidateFiles(path, code, ext) {
+		if serve(candidate, code, depth+1) {
+			return true
+		}
+	}
+	return false
+}
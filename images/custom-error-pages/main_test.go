@@ -0,0 +1,175 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newErrorRequest builds a request carrying the headers the default backend
+// expects from NGINX.
+func newErrorRequest(t *testing.T, code, format, serviceName, uri string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set(CodeHeader, code)
+	r.Header.Set(FormatHeader, format)
+	r.Header.Set(ServiceName, serviceName)
+	r.Header.Set(OriginalURI, uri)
+	return r
+}
+
+// assertCommonHeaders checks the headers every branch of errorHandler must
+// set identically, regardless of which file/body ends up being served.
+func assertCommonHeaders(t *testing.T, w *httptest.ResponseRecorder, wantCacheControl string) {
+	t.Helper()
+	h := w.Header()
+	if got := h.Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want %q", got, "nosniff")
+	}
+	if got := h.Get("Referrer-Policy"); got != "no-referrer" {
+		t.Errorf("Referrer-Policy = %q, want %q", got, "no-referrer")
+	}
+	if got := h.Get("Cache-Control"); got != wantCacheControl {
+		t.Errorf("Cache-Control = %q, want %q", got, wantCacheControl)
+	}
+}
+
+func TestErrorHandlerPrimaryFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "404.html"), []byte("primary 404"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	errorConfig.Store(&ErrorConfig{})
+
+	w := httptest.NewRecorder()
+	errorHandler(dir)(w, newErrorRequest(t, "404", "text/html", "", "/missing"))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+	if got := w.Body.String(); got != "primary 404" {
+		t.Fatalf("body = %q, want %q", got, "primary 404")
+	}
+	if got := w.Header().Get(ContentType); got != "text/html" {
+		t.Errorf("Content-Type = %q, want %q", got, "text/html")
+	}
+	assertCommonHeaders(t, w, defaultCacheControl4xx)
+}
+
+func TestErrorHandlerNxxFallback(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "4xx.html"), []byte("4xx fallback"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	errorConfig.Store(&ErrorConfig{})
+
+	w := httptest.NewRecorder()
+	errorHandler(dir)(w, newErrorRequest(t, "404", "text/html", "", "/missing"))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+	if got := w.Body.String(); got != "4xx fallback" {
+		t.Fatalf("body = %q, want %q", got, "4xx fallback")
+	}
+	assertCommonHeaders(t, w, defaultCacheControl4xx)
+}
+
+func TestErrorHandlerNotFound(t *testing.T) {
+	dir := t.TempDir()
+	errorConfig.Store(&ErrorConfig{})
+
+	w := httptest.NewRecorder()
+	errorHandler(dir)(w, newErrorRequest(t, "404", "text/html", "", "/missing"))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+	// setSecurityHeaders must run even on the http.NotFound branch.
+	assertCommonHeaders(t, w, defaultCacheControl4xx)
+}
+
+func TestErrorHandlerURIRuleOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "custom.json"), []byte(`{"ok":false}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	errorConfig.Store(&ErrorConfig{
+		Services: []ServiceOverride{{
+			ServiceName: "refresh",
+			URIRules: []URIRule{{
+				URIPattern:  "/version-checks/fresha",
+				File:        "custom.json",
+				ContentType: "application/vnd.api+json",
+			}},
+		}},
+	})
+	t.Cleanup(func() { errorConfig.Store(&ErrorConfig{}) })
+
+	w := httptest.NewRecorder()
+	errorHandler(dir)(w, newErrorRequest(t, "404", "text/html", "refresh", "/version-checks/fresha"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if got := w.Body.String(); got != `{"ok":false}` {
+		t.Fatalf("body = %q, want %q", got, `{"ok":false}`)
+	}
+	if got := w.Header().Get(ContentType); got != "application/vnd.api+json" {
+		t.Errorf("Content-Type = %q, want %q", got, "application/vnd.api+json")
+	}
+	assertCommonHeaders(t, w, defaultCacheControl4xx)
+}
+
+func TestErrorHandlerMaintenanceEnvOverride(t *testing.T) {
+	dir := t.TempDir()
+	const envVar = "TEST_CHUNK0_4_MAINTENANCE"
+	t.Setenv(envVar, "down for maintenance")
+	errorConfig.Store(&ErrorConfig{
+		Services: []ServiceOverride{{
+			ServiceName: "refresh",
+			Maintenance: []MaintenanceOverride{{
+				EnvVar:      envVar,
+				StatusCode:  http.StatusServiceUnavailable,
+				ContentType: "application/vnd.api+json",
+			}},
+			URIRules: []URIRule{{
+				URIPattern: "/version-checks/fresha",
+				File:       "custom.json",
+			}},
+		}},
+	})
+	t.Cleanup(func() { errorConfig.Store(&ErrorConfig{}) })
+
+	w := httptest.NewRecorder()
+	errorHandler(dir)(w, newErrorRequest(t, "404", "text/html", "refresh", "/version-checks/fresha"))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := w.Body.String(); got != "down for maintenance" {
+		t.Fatalf("body = %q, want %q", got, "down for maintenance")
+	}
+	if got := w.Header().Get(ContentType); got != "application/vnd.api+json" {
+		t.Errorf("Content-Type = %q, want %q", got, "application/vnd.api+json")
+	}
+	assertCommonHeaders(t, w, defaultCacheControl5xx)
+}
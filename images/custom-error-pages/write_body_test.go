@@ -0,0 +1,90 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteBodyNotModified covers the plain (uncompressed) 304 path: a
+// request whose If-Modified-Since is at or after the body's mtime gets a
+// 304 with no body, regardless of format.
+func TestWriteBodyNotModified(t *testing.T) {
+	modTime := time.Now().Truncate(time.Second)
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+
+	w := httptest.NewRecorder()
+	writeBody(w, r, "text/plain", http.StatusNotFound, "404.txt", modTime, strings.NewReader("not found"), 9, "")
+
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusNotModified)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q, want empty", w.Body.String())
+	}
+}
+
+// TestWriteBodyNotModifiedBypassesGzip is the regression test for the bug
+// where the on-the-fly gzip branch returned before ever reaching
+// http.ServeContent, so a compressible response at/above minCompressSize
+// from a gzip-accepting client never honored If-Modified-Since and always
+// paid for re-compression instead of returning 304.
+func TestWriteBodyNotModifiedBypassesGzip(t *testing.T) {
+	modTime := time.Now().Truncate(time.Second)
+	body := strings.Repeat("<html>error page</html>", 100) // well above minCompressSize
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+
+	w := httptest.NewRecorder()
+	writeBody(w, r, "text/html", http.StatusNotFound, "404.html", modTime, strings.NewReader(body), int64(len(body)), "")
+
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("status = %v, want %v (gzip path must not bypass the conditional check)", w.Code, http.StatusNotModified)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("Content-Encoding = %q, want unset on a 304", enc)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q, want empty", w.Body.String())
+	}
+}
+
+// TestWriteBodyOverrideCodeWinsWithZeroModTime asserts that in-memory
+// override bodies (maintenance responses, which carry a zero modTime since
+// they have no backing file) are never short-circuited by
+// the If-Modified-Since check, even if a client happens to send one.
+func TestWriteBodyOverrideCodeWinsWithZeroModTime(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).Format(http.TimeFormat))
+
+	w := httptest.NewRecorder()
+	writeBody(w, r, "application/json", http.StatusServiceUnavailable, "", time.Time{}, strings.NewReader("down for maintenance"), -1, "")
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %v, want %v (override code must win over a zero-modTime body)", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := w.Body.String(); got != "down for maintenance" {
+		t.Errorf("body = %q, want %q", got, "down for maintenance")
+	}
+}